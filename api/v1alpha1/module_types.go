@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 contains the API Schema definitions for the
+// timoni.sh Module custom resource.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ValuesReference points at a ConfigMap or Secret key holding a CUE values
+// file to merge on top of the module's default values.
+type ValuesReference struct {
+	// Kind of the values source, either ConfigMap or Secret.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+
+	// Name of the values source.
+	Name string `json:"name"`
+
+	// Key within the values source holding the CUE values file.
+	Key string `json:"key"`
+}
+
+// ModuleSpec mirrors the flags accepted by `timoni apply`.
+type ModuleSpec struct {
+	// Module is the OCI URL or local path of the module.
+	Module string `json:"module"`
+
+	// Version is the semver range or exact version of the module.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Package is the name of the CUE package containing the instance
+	// values and resources.
+	// +optional
+	// +kubebuilder:default=main
+	Package string `json:"package,omitempty"`
+
+	// Values holds the instance values as an inline CUE expression.
+	// +optional
+	Values string `json:"values,omitempty"`
+
+	// ValuesFrom holds references to ConfigMaps/Secrets with CUE values
+	// files, merged in order on top of Values.
+	// +optional
+	ValuesFrom []ValuesReference `json:"valuesFrom,omitempty"`
+
+	// Wait instructs the controller to wait for the applied resources to
+	// become ready before marking the instance as Ready.
+	// +optional
+	// +kubebuilder:default=true
+	Wait bool `json:"wait,omitempty"`
+
+	// Prune instructs the controller to remove the resources that are no
+	// longer part of the module.
+	// +optional
+	// +kubebuilder:default=true
+	Prune bool `json:"prune,omitempty"`
+
+	// Interval at which the module is reconciled for drift correction.
+	// +optional
+	// +kubebuilder:default="10m"
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// ModuleConditionType is the type of a Module status condition.
+type ModuleConditionType string
+
+const (
+	// ModuleReadyCondition indicates the module was successfully applied
+	// and, if Wait is set, that its resources became ready.
+	ModuleReadyCondition ModuleConditionType = "Ready"
+
+	// ModuleReconcilingCondition indicates a reconciliation is in progress.
+	ModuleReconcilingCondition ModuleConditionType = "Reconciling"
+
+	// ModuleStalledCondition indicates the reconciler can't make progress
+	// without a change to the spec (e.g. an invalid module reference).
+	ModuleStalledCondition ModuleConditionType = "Stalled"
+)
+
+// ModuleStatus records the result of the last reconciliation.
+type ModuleStatus struct {
+	// Conditions holds the Ready/Reconciling/Stalled conditions.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Inventory is the list of Kubernetes objects applied by the last
+	// reconciliation, each given as its Kind/namespace/name change set subject.
+	// +optional
+	Inventory []string `json:"inventory,omitempty"`
+
+	// LastAppliedRevision is the module version that was last applied
+	// successfully.
+	// +optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=mod
+
+// Module is the Schema for the modules API. Its spec mirrors the flags of
+// `timoni apply` so that instances can be managed declaratively via
+// `kubectl apply` instead of the CLI.
+type Module struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ModuleSpec   `json:"spec,omitempty"`
+	Status ModuleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ModuleList contains a list of Module.
+type ModuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Module `json:"items"`
+}
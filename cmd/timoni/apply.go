@@ -18,6 +18,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -29,9 +30,59 @@ import (
 	"github.com/fluxcd/pkg/ssa"
 	"github.com/spf13/cobra"
 	"github.com/stefanprodan/timoni/pkg/inventory"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/yaml"
 )
 
+// applyError pairs a pipeline failure with the object it was acting on, so
+// that aggregated errors can be traced back to the offending resource.
+type applyError struct {
+	stage  string
+	object *unstructured.Unstructured
+	err    error
+}
+
+func (e *applyError) Error() string {
+	if e.object == nil {
+		return fmt.Sprintf("%s: %s", e.stage, e.err)
+	}
+	return fmt.Sprintf("%s: %s %s: %s",
+		e.stage, e.object.GroupVersionKind().String(), e.object.GetName(), e.err)
+}
+
+// multiError aggregates the failures collected across the apply pipeline so
+// that all of them are reported at once instead of on the first error.
+type multiError struct {
+	errs []error
+}
+
+func (m *multiError) Add(err error) {
+	if err != nil {
+		m.errs = append(m.errs, err)
+	}
+}
+
+func (m *multiError) HasErrors() bool {
+	return len(m.errs) > 0
+}
+
+func (m *multiError) Error() string {
+	msgs := make([]string, len(m.errs))
+	for i, err := range m.errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// applyResult is the JSON representation of an apply run, emitted when
+// --output=json is set so that CI pipelines can parse partial failures.
+type applyResult struct {
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	Succeeded bool     `json:"succeeded"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
 var applyCmd = &cobra.Command{
 	Use:     "apply [NAME] [URL]",
 	Aliases: []string{"install", "upgrade"},
@@ -61,6 +112,7 @@ type applyFlags struct {
 	diff        bool
 	wait        bool
 	creds       string
+	output      string
 }
 
 var applyArgs applyFlags
@@ -80,6 +132,8 @@ func init() {
 		"wait for the applied Kubernetes objects to become ready")
 	applyCmd.Flags().StringVar(&applyArgs.creds, "creds", "",
 		"credentials for the container registry in the format <username>[:<password>]")
+	applyCmd.Flags().StringVarP(&applyArgs.output, "output", "o", "",
+		"the format in which the apply result should be printed, can be 'json'")
 	rootCmd.AddCommand(applyCmd)
 }
 
@@ -143,6 +197,8 @@ func runApplyCmd(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), rootArgs.timeout)
 	defer cancel()
 
+	merr := &multiError{}
+
 	if applyArgs.dryrun {
 		diffOpts := ssa.DefaultDiffOptions()
 		sort.Sort(ssa.SortableUnstructureds(objects))
@@ -150,6 +206,7 @@ func runApplyCmd(cmd *cobra.Command, args []string) error {
 			change, liveObject, mergedObject, err := sm.Diff(ctx, r, diffOpts)
 			if err != nil {
 				logger.Println(err)
+				merr.Add(&applyError{stage: "diff", object: r, err: err})
 				continue
 			}
 
@@ -159,13 +216,15 @@ func runApplyCmd(cmd *cobra.Command, args []string) error {
 				liveYAML, _ := yaml.Marshal(liveObject)
 				liveFile := filepath.Join(tmpDir, "live.yaml")
 				if err := os.WriteFile(liveFile, liveYAML, 0644); err != nil {
-					return err
+					merr.Add(&applyError{stage: "diff", object: r, err: err})
+					continue
 				}
 
 				mergedYAML, _ := yaml.Marshal(mergedObject)
 				mergedFile := filepath.Join(tmpDir, "merged.yaml")
 				if err := os.WriteFile(mergedFile, mergedYAML, 0644); err != nil {
-					return err
+					merr.Add(&applyError{stage: "diff", object: r, err: err})
+					continue
 				}
 
 				out, _ := exec.Command("diff", "-N", "-u", liveFile, mergedFile).Output()
@@ -176,7 +235,7 @@ func runApplyCmd(cmd *cobra.Command, args []string) error {
 				}
 			}
 		}
-		return nil
+		return finishApplyCmd(merr)
 	}
 
 	invStorage := &inventory.Storage{Manager: sm, Owner: so}
@@ -188,48 +247,76 @@ func runApplyCmd(cmd *cobra.Command, args []string) error {
 
 	cs, err := sm.ApplyAllStaged(ctx, objects, ssa.DefaultApplyOptions())
 	if err != nil {
-		return err
+		merr.Add(&applyError{stage: "apply", err: err})
 	}
-	for _, change := range cs.Entries {
-		logger.Println(change.String())
+	if cs != nil {
+		for _, change := range cs.Entries {
+			logger.Println(change.String())
+		}
 	}
 
 	staleObjects, err := invStorage.GetInventoryStaleObjects(ctx, newInventory)
 	if err != nil {
-		return fmt.Errorf("inventory query failed, error: %w", err)
+		merr.Add(&applyError{stage: "inventory query", err: err})
+		return finishApplyCmd(merr)
 	}
 
-	err = invStorage.ApplyInventory(ctx, newInventory, true)
-	if err != nil {
-		return fmt.Errorf("inventory apply failed, error: %w", err)
+	if err := invStorage.ApplyInventory(ctx, newInventory, true); err != nil {
+		merr.Add(&applyError{stage: "inventory apply", err: err})
 	}
 
 	if len(staleObjects) > 0 {
 		changeSet, err := sm.DeleteAll(ctx, staleObjects, ssa.DefaultDeleteOptions())
 		if err != nil {
-			return fmt.Errorf("prune failed, error: %w", err)
+			merr.Add(&applyError{stage: "prune", err: err})
 		}
-		for _, change := range changeSet.Entries {
-			logger.Println(change.String())
+		if changeSet != nil {
+			for _, change := range changeSet.Entries {
+				logger.Println(change.String())
+			}
 		}
 	}
 
 	if applyArgs.wait {
 		logger.Println(fmt.Sprintf("waiting for %v resource(s) to become ready...", len(objects)))
-		err = sm.Wait(objects, ssa.DefaultWaitOptions())
-		if err != nil {
-			return err
+		if err := sm.Wait(objects, ssa.DefaultWaitOptions()); err != nil {
+			merr.Add(&applyError{stage: "wait", err: err})
 		}
 
 		if len(staleObjects) > 0 {
 			logger.Println(fmt.Sprintf("waiting for %v resource(s) to be finalized...", len(staleObjects)))
-			err = sm.WaitForTermination(staleObjects, ssa.DefaultWaitOptions())
-			if err != nil {
-				return fmt.Errorf("wating for termination failed, error: %w", err)
+			if err := sm.WaitForTermination(staleObjects, ssa.DefaultWaitOptions()); err != nil {
+				merr.Add(&applyError{stage: "wait-for-termination", err: err})
 			}
 		}
 
-		logger.Println("all resources are ready")
+		if !merr.HasErrors() {
+			logger.Println("all resources are ready")
+		}
+	}
+
+	return finishApplyCmd(merr)
+}
+
+// finishApplyCmd reports the errors aggregated across the apply pipeline. If
+// --output=json was requested, the result is additionally printed as a
+// single JSON object so that CI pipelines can parse partial failures.
+func finishApplyCmd(merr *multiError) error {
+	if applyArgs.output == "json" {
+		result := applyResult{
+			Name:      applyArgs.name,
+			Namespace: *kubeconfigArgs.Namespace,
+			Succeeded: !merr.HasErrors(),
+		}
+		for _, err := range merr.errs {
+			result.Errors = append(result.Errors, err.Error())
+		}
+		enc, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(enc))
+	}
+
+	if merr.HasErrors() {
+		return merr
 	}
 
 	return nil
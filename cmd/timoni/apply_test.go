@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMultiError(t *testing.T) {
+	merr := &multiError{}
+	if merr.HasErrors() {
+		t.Fatal("HasErrors() = true on an empty multiError")
+	}
+
+	merr.Add(nil)
+	if merr.HasErrors() {
+		t.Fatal("HasErrors() = true after adding a nil error")
+	}
+
+	merr.Add(fmt.Errorf("first"))
+	merr.Add(fmt.Errorf("second"))
+	if !merr.HasErrors() {
+		t.Fatal("HasErrors() = false after adding errors")
+	}
+
+	want := "first; second"
+	if got := merr.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestFinishApplyCmdJSONOutput(t *testing.T) {
+	origOutput := applyArgs.output
+	origName := applyArgs.name
+	defer func() {
+		applyArgs.output = origOutput
+		applyArgs.name = origName
+	}()
+
+	applyArgs.output = "json"
+	applyArgs.name = "test-instance"
+
+	merr := &multiError{}
+	merr.Add(&applyError{stage: "apply", err: fmt.Errorf("boom")})
+
+	stdout := captureStdout(t, func() {
+		if err := finishApplyCmd(merr); err == nil {
+			t.Fatal("finishApplyCmd() error = nil, want the aggregated error")
+		}
+	})
+
+	var result applyResult
+	if err := json.Unmarshal(stdout, &result); err != nil {
+		t.Fatalf("output is not valid JSON: %v\noutput: %s", err, stdout)
+	}
+
+	if result.Name != "test-instance" {
+		t.Errorf("result.Name = %q, want %q", result.Name, "test-instance")
+	}
+	if result.Succeeded {
+		t.Error("result.Succeeded = true, want false")
+	}
+	if len(result.Errors) != 1 || result.Errors[0] == "" {
+		t.Errorf("result.Errors = %v, want one non-empty entry", result.Errors)
+	}
+}
+
+func TestFinishApplyCmdNoErrors(t *testing.T) {
+	origOutput := applyArgs.output
+	defer func() { applyArgs.output = origOutput }()
+	applyArgs.output = ""
+
+	if err := finishApplyCmd(&multiError{}); err != nil {
+		t.Fatalf("finishApplyCmd() error = %v, want nil", err)
+	}
+}
+
+// captureStdout redirects os.Stdout for the duration of fn and returns what
+// was written to it.
+func captureStdout(t *testing.T, fn func()) []byte {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	fn()
+
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.TrimSpace(out)
+}
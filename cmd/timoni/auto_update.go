@@ -0,0 +1,385 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/Masterminds/semver/v3"
+	"github.com/fluxcd/pkg/ssa"
+	"github.com/spf13/cobra"
+	"github.com/stefanprodan/timoni/pkg/inventory"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// updatePolicyAnnotation marks an instance's inventory ConfigMap with the
+// policy auto-update should use to pick its next version: "registry" for the
+// latest tag in the OCI registry, or a semver range such as "~1.2".
+const updatePolicyAnnotation = "apply.timoni.sh/update"
+
+var autoUpdateCmd = &cobra.Command{
+	Use:   "auto-update",
+	Short: "Upgrade instances to newer module versions",
+	Long: `The auto-update command walks every instance recorded in the cluster
+inventories and, for the ones annotated with ` + "`" + updatePolicyAnnotation + "`" + `,
+upgrades them to the newest module version allowed by their update policy.`,
+	RunE: runAutoUpdateCmd,
+}
+
+type autoUpdateFlags struct {
+	dryrun bool
+	creds  string
+}
+
+var autoUpdateArgs autoUpdateFlags
+
+func init() {
+	autoUpdateCmd.Flags().BoolVar(&autoUpdateArgs.dryrun, "dry-run", false,
+		"only print the updates that would be performed")
+	autoUpdateCmd.Flags().StringVar(&autoUpdateArgs.creds, "creds", "",
+		"credentials for the container registry in the format <username>[:<password>]")
+	rootCmd.AddCommand(autoUpdateCmd)
+}
+
+type autoUpdateResult struct {
+	instance string
+	from     string
+	to       string
+	status   string
+	reason   string
+}
+
+func runAutoUpdateCmd(cmd *cobra.Command, args []string) error {
+	so := ssa.Owner{
+		Field: "timoni",
+		Group: "timoni.mod",
+	}
+
+	sm, err := newManager(so)
+	if err != nil {
+		return err
+	}
+
+	invStorage := &inventory.Storage{Manager: sm, Owner: so}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rootArgs.timeout)
+	defer cancel()
+
+	instances, err := invStorage.List(ctx)
+	if err != nil {
+		return fmt.Errorf("listing instances failed, error: %w", err)
+	}
+
+	var results []autoUpdateResult
+	for _, i := range instances {
+		policy := i.Annotations[updatePolicyAnnotation]
+		if policy == "" {
+			continue
+		}
+
+		result := autoUpdateResult{instance: i.Name, from: i.Revision}
+		next, err := nextModuleVersion(ctx, i.Source, i.Revision, policy, autoUpdateArgs.creds)
+		if err != nil {
+			result.status = "failed"
+			result.reason = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		if next == "" || next == i.Revision {
+			result.to = i.Revision
+			result.status = "skipped"
+			result.reason = "already up to date"
+			results = append(results, result)
+			continue
+		}
+
+		result.to = next
+
+		if autoUpdateArgs.dryrun {
+			pkg := i.Package
+			if pkg == "" {
+				pkg = "main"
+			}
+
+			objects, err := buildInstanceObjects(ctx, i.Name, i.Namespace, i.Source, next, pkg, autoUpdateArgs.creds)
+			if err != nil {
+				result.status = "failed"
+				result.reason = err.Error()
+				results = append(results, result)
+				continue
+			}
+			sm.SetOwnerLabels(objects, i.Name, i.Namespace)
+			printUpdateDiff(ctx, sm, objects)
+
+			result.status = "dry-run"
+			results = append(results, result)
+			continue
+		}
+
+		if err := updateInstance(ctx, sm, invStorage, i, next); err != nil {
+			result.status = "failed"
+			result.reason = err.Error()
+		} else {
+			result.status = "updated"
+		}
+		results = append(results, result)
+	}
+
+	printAutoUpdateSummary(results)
+
+	return nil
+}
+
+// nextModuleVersion queries the OCI registry for the tags available for
+// module, then picks the highest one allowed by policy: "registry" means the
+// latest tag, anything else is parsed as a semver range (e.g. "~1.2").
+// Returns an empty string if that tag isn't newer than current; the check is
+// skipped when current isn't a valid semver version.
+func nextModuleVersion(ctx context.Context, module, current, policy, creds string) (string, error) {
+	fetcher := NewFetcher(ctx, module, "", "", creds)
+	tags, err := fetcher.ListTags()
+	if err != nil {
+		return "", fmt.Errorf("listing tags for %s failed, error: %w", module, err)
+	}
+
+	next, err := pickNextVersion(tags, policy)
+	if err != nil || next == "" {
+		return next, err
+	}
+
+	if cur, err := semver.NewVersion(current); err == nil {
+		if v, err := semver.NewVersion(next); err == nil && !v.GreaterThan(cur) {
+			return "", nil
+		}
+	}
+
+	return next, nil
+}
+
+// pickNextVersion picks the highest semver tag allowed by policy: "registry"
+// means the latest tag, anything else is parsed as a semver range (e.g.
+// "~1.2"). Tags that aren't valid semver are ignored. Returns an empty
+// string, with no error, if none of the tags match.
+func pickNextVersion(tags []string, policy string) (string, error) {
+	var constraint *semver.Constraints
+	if policy != "registry" {
+		var err error
+		constraint, err = semver.NewConstraint(policy)
+		if err != nil {
+			return "", fmt.Errorf("invalid update policy %q, error: %w", policy, err)
+		}
+	}
+
+	var latest *semver.Version
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if constraint != nil && !constraint.Check(v) {
+			continue
+		}
+		if latest == nil || v.GreaterThan(latest) {
+			latest = v
+		}
+	}
+
+	if latest == nil {
+		return "", nil
+	}
+
+	return latest.Original(), nil
+}
+
+// printUpdateDiff prints the server dry-run change line for each object, as
+// the apply command's --dry-run branch does; the unified diff that apply adds
+// with --diff is not printed.
+func printUpdateDiff(ctx context.Context, sm *ssa.ResourceManager, objects []*unstructured.Unstructured) {
+	diffOpts := ssa.DefaultDiffOptions()
+	sort.Sort(ssa.SortableUnstructureds(objects))
+	for _, r := range objects {
+		change, _, _, err := sm.Diff(ctx, r, diffOpts)
+		if err != nil {
+			logger.Println(err)
+			continue
+		}
+		logger.Println(change.String(), "(server dry run)")
+	}
+}
+
+func updateInstance(ctx context.Context, sm *ssa.ResourceManager, invStorage *inventory.Storage, i *inventory.Instance, version string) error {
+	pkg := i.Package
+	if pkg == "" {
+		pkg = "main"
+	}
+
+	objects, err := buildInstanceObjects(ctx, i.Name, i.Namespace, i.Source, version, pkg, autoUpdateArgs.creds)
+	if err != nil {
+		return err
+	}
+	sm.SetOwnerLabels(objects, i.Name, i.Namespace)
+
+	cs, err := sm.ApplyAllStaged(ctx, objects, ssa.DefaultApplyOptions())
+	if err != nil {
+		return fmt.Errorf("apply failed, error: %w", err)
+	}
+	for _, change := range cs.Entries {
+		logger.Println(change.String())
+	}
+
+	newInventory := inventory.NewInventory(i.Name, i.Namespace)
+	newInventory.SetSource(i.Source, version, i.Values)
+	if err := newInventory.AddObjects(objects); err != nil {
+		return fmt.Errorf("creating inventory failed, error: %w", err)
+	}
+
+	staleObjects, err := invStorage.GetInventoryStaleObjects(ctx, newInventory)
+	if err != nil {
+		return fmt.Errorf("inventory query failed, error: %w", err)
+	}
+
+	if err := invStorage.ApplyInventory(ctx, newInventory, true); err != nil {
+		return fmt.Errorf("inventory apply failed, error: %w", err)
+	}
+
+	if len(staleObjects) > 0 {
+		changeSet, err := sm.DeleteAll(ctx, staleObjects, ssa.DefaultDeleteOptions())
+		if err != nil {
+			return fmt.Errorf("prune failed, error: %w", err)
+		}
+		for _, change := range changeSet.Entries {
+			logger.Println(change.String())
+		}
+	}
+
+	if err := sm.Wait(objects, ssa.DefaultWaitOptions()); err != nil {
+		logger.Println(fmt.Sprintf("instance %s: rolling back to %s, error: %v", i.Name, i.Revision, err))
+		if rbErr := rollbackInstance(ctx, sm, invStorage, i, pkg); rbErr != nil {
+			return fmt.Errorf("wait failed (%w) and rollback failed, error: %w", err, rbErr)
+		}
+		return fmt.Errorf("update did not become ready, rolled back to %s, error: %w", i.Revision, err)
+	}
+
+	if len(staleObjects) > 0 {
+		if err := sm.WaitForTermination(staleObjects, ssa.DefaultWaitOptions()); err != nil {
+			return fmt.Errorf("waiting for termination failed, error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// rollbackInstance re-fetches and re-applies the instance's previous module
+// revision, restoring both the live cluster state and the inventory
+// bookkeeping to what they were before the failed update.
+func rollbackInstance(ctx context.Context, sm *ssa.ResourceManager, invStorage *inventory.Storage, i *inventory.Instance, pkg string) error {
+	objects, err := buildInstanceObjects(ctx, i.Name, i.Namespace, i.Source, i.Revision, pkg, autoUpdateArgs.creds)
+	if err != nil {
+		return fmt.Errorf("rebuilding previous revision failed, error: %w", err)
+	}
+	sm.SetOwnerLabels(objects, i.Name, i.Namespace)
+
+	if _, err := sm.ApplyAllStaged(ctx, objects, ssa.DefaultApplyOptions()); err != nil {
+		return fmt.Errorf("re-applying previous revision failed, error: %w", err)
+	}
+
+	previousInventory := inventory.NewInventory(i.Name, i.Namespace)
+	previousInventory.SetSource(i.Source, i.Revision, i.Values)
+	if err := previousInventory.AddObjects(objects); err != nil {
+		return fmt.Errorf("restoring previous inventory failed, error: %w", err)
+	}
+
+	// The failed update's inventory is still the one on record at this
+	// point, so diffing the restored inventory against it finds the extra
+	// objects the failed revision added and the previous one doesn't have.
+	staleObjects, err := invStorage.GetInventoryStaleObjects(ctx, previousInventory)
+	if err != nil {
+		return fmt.Errorf("inventory query failed, error: %w", err)
+	}
+
+	if err := invStorage.ApplyInventory(ctx, previousInventory, true); err != nil {
+		return fmt.Errorf("restoring previous inventory failed, error: %w", err)
+	}
+
+	if len(staleObjects) > 0 {
+		changeSet, err := sm.DeleteAll(ctx, staleObjects, ssa.DefaultDeleteOptions())
+		if err != nil {
+			return fmt.Errorf("pruning resources added by the failed update failed, error: %w", err)
+		}
+		for _, change := range changeSet.Entries {
+			logger.Println(change.String())
+		}
+	}
+
+	if err := sm.Wait(objects, ssa.DefaultWaitOptions()); err != nil {
+		return err
+	}
+
+	if len(staleObjects) > 0 {
+		if err := sm.WaitForTermination(staleObjects, ssa.DefaultWaitOptions()); err != nil {
+			return fmt.Errorf("waiting for termination failed, error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// buildInstanceObjects fetches the given module revision and builds the
+// Kubernetes objects for the named instance.
+func buildInstanceObjects(ctx context.Context, name, namespace, module, version, pkg, creds string) ([]*unstructured.Unstructured, error) {
+	tmpDir, err := os.MkdirTemp("", "timoni-auto-update")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fetcher := NewFetcher(ctx, module, version, tmpDir, creds)
+	modulePath, err := fetcher.Fetch()
+	if err != nil {
+		return nil, fmt.Errorf("fetching module failed, error: %w", err)
+	}
+
+	cuectx := cuecontext.New()
+	builder := NewBuilder(cuectx, name, namespace, modulePath, pkg)
+
+	buildResult, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("build failed, error: %w", err)
+	}
+
+	objects, err := builder.GetObjects(buildResult)
+	if err != nil {
+		return nil, fmt.Errorf("extracting resources failed, error: %w", err)
+	}
+
+	return objects, nil
+}
+
+func printAutoUpdateSummary(results []autoUpdateResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tFROM\tTO\tSTATUS\tREASON")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.instance, r.from, r.to, r.status, r.reason)
+	}
+	w.Flush()
+}
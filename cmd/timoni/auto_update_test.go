@@ -0,0 +1,52 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestPickNextVersion(t *testing.T) {
+	tags := []string{"1.0.0", "1.2.0", "1.2.1", "2.0.0", "not-a-version"}
+
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{"registry picks the latest tag", "registry", "2.0.0"},
+		{"semver range restricts to the matching minor", "~1.2", "1.2.1"},
+		{"semver range restricts to the matching major", "^1.0.0", "1.2.1"},
+		{"no tag satisfies the range", "^3.0.0", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickNextVersion(tags, tt.policy)
+			if err != nil {
+				t.Fatalf("pickNextVersion() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("pickNextVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickNextVersionInvalidPolicy(t *testing.T) {
+	if _, err := pickNextVersion([]string{"1.0.0"}, "not-a-constraint"); err == nil {
+		t.Fatal("expected an error for an invalid update policy, got nil")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/load"
+	"github.com/spf13/cobra"
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Commands for managing multi-module deployments",
+}
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+}
+
+// bundleInstance mirrors a single entry of the `instances: [...]` list
+// declared in a bundle.cue file.
+type bundleInstance struct {
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	Module    string   `json:"module"`
+	Version   string   `json:"version"`
+	Package   string   `json:"package"`
+	Values    []string `json:"values"`
+}
+
+// loadBundleInstances decodes the `instances: [...]` list from the given
+// bundle.cue file into a slice of bundleInstance, in declaration order.
+func loadBundleInstances(cuectx *cue.Context, file string) ([]bundleInstance, error) {
+	buildInstances := load.Instances([]string{file}, nil)
+	if len(buildInstances) == 0 {
+		return nil, fmt.Errorf("no bundle found at %s", file)
+	}
+	if err := buildInstances[0].Err; err != nil {
+		return nil, fmt.Errorf("failed to load bundle %s, error: %w", file, err)
+	}
+
+	bundleValue := cuectx.BuildInstance(buildInstances[0])
+	if bundleValue.Err() != nil {
+		return nil, fmt.Errorf("failed to parse bundle %s, error: %w", file, bundleValue.Err())
+	}
+
+	instancesValue := bundleValue.LookupPath(cue.ParsePath("instances"))
+	if !instancesValue.Exists() {
+		return nil, fmt.Errorf("bundle %s has no instances field", file)
+	}
+
+	var instances []bundleInstance
+	if err := instancesValue.Decode(&instances); err != nil {
+		return nil, fmt.Errorf("failed to decode instances in bundle %s, error: %w", file, err)
+	}
+
+	for i := range instances {
+		if instances[i].Package == "" {
+			instances[i].Package = "main"
+		}
+	}
+
+	return instances, nil
+}
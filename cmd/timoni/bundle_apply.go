@@ -0,0 +1,199 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/fluxcd/pkg/ssa"
+	"github.com/spf13/cobra"
+	"github.com/stefanprodan/timoni/pkg/inventory"
+)
+
+var bundleApplyCmd = &cobra.Command{
+	Use:   "apply -f [BUNDLE]",
+	Short: "Install or upgrade the instances declared in a bundle",
+	Example: `  # Apply all the instances declared in a bundle
+  timoni bundle apply -f ./bundle.cue
+`,
+	RunE: runBundleApplyCmd,
+}
+
+type bundleApplyFlags struct {
+	file  string
+	wait  bool
+	creds string
+}
+
+var bundleApplyArgs bundleApplyFlags
+
+func init() {
+	bundleApplyCmd.Flags().StringVarP(&bundleApplyArgs.file, "file", "f", "",
+		"local path to a bundle.cue file")
+	bundleApplyCmd.Flags().BoolVar(&bundleApplyArgs.wait, "wait", true,
+		"wait for the applied Kubernetes objects to become ready")
+	bundleApplyCmd.Flags().StringVar(&bundleApplyArgs.creds, "creds", "",
+		"credentials for the container registry in the format <username>[:<password>]")
+	bundleCmd.AddCommand(bundleApplyCmd)
+}
+
+func runBundleApplyCmd(cmd *cobra.Command, args []string) error {
+	if bundleApplyArgs.file == "" {
+		return fmt.Errorf("a bundle file is required")
+	}
+
+	startTime := time.Now()
+
+	cuectx := cuecontext.New()
+	instances, err := loadBundleInstances(cuectx, bundleApplyArgs.file)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("no instances found in bundle %s", bundleApplyArgs.file)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "timoni-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ctxPull, cancel := context.WithTimeout(context.Background(), rootArgs.timeout)
+	defer cancel()
+
+	// Pull every referenced module before applying anything, so a missing
+	// or broken artifact fails fast without leaving a half-applied bundle.
+	modulePaths := make(map[string]string, len(instances))
+	for _, instance := range instances {
+		logger.Println("pulling", instance.Module, "for instance", instance.Name)
+
+		instanceDir := filepath.Join(tmpDir, instance.Name)
+		fetcher := NewFetcher(ctxPull, instance.Module, instance.Version, instanceDir, bundleApplyArgs.creds)
+		modulePath, err := fetcher.Fetch()
+		if err != nil {
+			return fmt.Errorf("pulling %s failed: %w", instance.Module, err)
+		}
+		modulePaths[instance.Name] = modulePath
+	}
+
+	so := ssa.Owner{
+		Field: "timoni",
+		Group: "timoni.mod",
+	}
+
+	sm, err := newManager(so)
+	if err != nil {
+		return err
+	}
+
+	// Shared across every instance in the bundle; each instance's inventory
+	// is still written on its own as that instance is applied, so a failure
+	// part way through leaves the instances applied before it in place.
+	invStorage := &inventory.Storage{Manager: sm, Owner: so}
+
+	for _, instance := range instances {
+		logger.Println("applying instance", instance.Name)
+		if err := applyBundleInstance(cuectx, sm, invStorage, instance, modulePaths[instance.Name]); err != nil {
+			return fmt.Errorf("instance %s: %w", instance.Name, err)
+		}
+	}
+
+	logger.Println(fmt.Sprintf("bundle applied in %s", time.Since(startTime).Round(time.Second)))
+
+	return nil
+}
+
+func applyBundleInstance(cuectx *cue.Context, sm *ssa.ResourceManager, invStorage *inventory.Storage, instance bundleInstance, modulePath string) error {
+	builder := NewBuilder(cuectx, instance.Name, instance.Namespace, modulePath, instance.Package)
+
+	if len(instance.Values) > 0 {
+		if err := builder.MergeValuesFile(instance.Values); err != nil {
+			return err
+		}
+	}
+
+	buildResult, err := builder.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build instance, error: %w", err)
+	}
+
+	objects, err := builder.GetObjects(buildResult)
+	if err != nil {
+		return fmt.Errorf("failed to extract resouces, error: %w", err)
+	}
+
+	sm.SetOwnerLabels(objects, instance.Name, instance.Namespace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), rootArgs.timeout)
+	defer cancel()
+
+	newInventory := inventory.NewInventory(instance.Name, instance.Namespace)
+	newInventory.SetSource(instance.Module, instance.Version, instance.Values)
+	if err := newInventory.AddObjects(objects); err != nil {
+		return fmt.Errorf("creating inventory failed, error: %w", err)
+	}
+
+	cs, err := sm.ApplyAllStaged(ctx, objects, ssa.DefaultApplyOptions())
+	if err != nil {
+		return err
+	}
+	for _, change := range cs.Entries {
+		logger.Println(change.String())
+	}
+
+	staleObjects, err := invStorage.GetInventoryStaleObjects(ctx, newInventory)
+	if err != nil {
+		return fmt.Errorf("inventory query failed, error: %w", err)
+	}
+
+	if err := invStorage.ApplyInventory(ctx, newInventory, true); err != nil {
+		return fmt.Errorf("inventory apply failed, error: %w", err)
+	}
+
+	if len(staleObjects) > 0 {
+		changeSet, err := sm.DeleteAll(ctx, staleObjects, ssa.DefaultDeleteOptions())
+		if err != nil {
+			return fmt.Errorf("prune failed, error: %w", err)
+		}
+		for _, change := range changeSet.Entries {
+			logger.Println(change.String())
+		}
+	}
+
+	if bundleApplyArgs.wait {
+		logger.Println(fmt.Sprintf("waiting for %v resource(s) to become ready...", len(objects)))
+		if err := sm.Wait(objects, ssa.DefaultWaitOptions()); err != nil {
+			return err
+		}
+
+		if len(staleObjects) > 0 {
+			logger.Println(fmt.Sprintf("waiting for %v resource(s) to be finalized...", len(staleObjects)))
+			if err := sm.WaitForTermination(staleObjects, ssa.DefaultWaitOptions()); err != nil {
+				return fmt.Errorf("wating for termination failed, error: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/fluxcd/pkg/ssa"
+	"github.com/spf13/cobra"
+	"github.com/stefanprodan/timoni/pkg/inventory"
+)
+
+var bundleDeleteCmd = &cobra.Command{
+	Use:     "delete -f [BUNDLE]",
+	Aliases: []string{"uninstall"},
+	Short:   "Uninstall the instances declared in a bundle",
+	Example: `  # Uninstall all the instances declared in a bundle, in reverse order
+  timoni bundle delete -f ./bundle.cue
+`,
+	RunE: runBundleDeleteCmd,
+}
+
+type bundleDeleteFlags struct {
+	file string
+	wait bool
+}
+
+var bundleDeleteArgs bundleDeleteFlags
+
+func init() {
+	bundleDeleteCmd.Flags().StringVarP(&bundleDeleteArgs.file, "file", "f", "",
+		"local path to a bundle.cue file")
+	bundleDeleteCmd.Flags().BoolVar(&bundleDeleteArgs.wait, "wait", true,
+		"wait for the uninstalled Kubernetes objects to be finalized")
+	bundleCmd.AddCommand(bundleDeleteCmd)
+}
+
+func runBundleDeleteCmd(cmd *cobra.Command, args []string) error {
+	if bundleDeleteArgs.file == "" {
+		return fmt.Errorf("a bundle file is required")
+	}
+
+	cuectx := cuecontext.New()
+	instances, err := loadBundleInstances(cuectx, bundleDeleteArgs.file)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("no instances found in bundle %s", bundleDeleteArgs.file)
+	}
+
+	so := ssa.Owner{
+		Field: "timoni",
+		Group: "timoni.mod",
+	}
+
+	sm, err := newManager(so)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rootArgs.timeout)
+	defer cancel()
+
+	// Uninstall in reverse declaration order, so dependents go before
+	// the instances they depend on.
+	for i := len(instances) - 1; i >= 0; i-- {
+		instance := instances[i]
+		logger.Println("deleting instance", instance.Name)
+
+		invStorage := &inventory.Storage{Manager: sm, Owner: so}
+		curInventory := inventory.NewInventory(instance.Name, instance.Namespace)
+
+		objects, err := invStorage.GetInventoryObjects(curInventory)
+		if err != nil {
+			return fmt.Errorf("instance %s: reading inventory failed, error: %w", instance.Name, err)
+		}
+
+		changeSet, err := sm.DeleteAll(ctx, objects, ssa.DefaultDeleteOptions())
+		if err != nil {
+			return fmt.Errorf("instance %s: uninstall failed, error: %w", instance.Name, err)
+		}
+		for _, change := range changeSet.Entries {
+			logger.Println(change.String())
+		}
+
+		if err := invStorage.Remove(ctx, curInventory); err != nil {
+			return fmt.Errorf("instance %s: deleting inventory failed, error: %w", instance.Name, err)
+		}
+
+		if bundleDeleteArgs.wait && len(objects) > 0 {
+			logger.Println(fmt.Sprintf("waiting for %v resource(s) to be finalized...", len(objects)))
+			if err := sm.WaitForTermination(objects, ssa.DefaultWaitOptions()); err != nil {
+				return fmt.Errorf("instance %s: waiting for termination failed, error: %w", instance.Name, err)
+			}
+		}
+	}
+
+	logger.Println("all instances have been uninstalled")
+
+	return nil
+}
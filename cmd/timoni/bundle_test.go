@@ -0,0 +1,84 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+)
+
+func TestLoadBundleInstances(t *testing.T) {
+	bundle := `
+instances: [
+	{
+		name:      "app1"
+		namespace: "apps"
+		module:    "oci://registry/app1"
+		version:   "1.0.0"
+		values: ["values.cue"]
+	},
+	{
+		name:      "app2"
+		namespace: "apps"
+		module:    "oci://registry/app2"
+		version:   "2.0.0"
+		package:   "staging"
+	},
+]
+`
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bundle.cue")
+	if err := os.WriteFile(file, []byte(bundle), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := loadBundleInstances(cuecontext.New(), file)
+	if err != nil {
+		t.Fatalf("loadBundleInstances() error = %v", err)
+	}
+	if len(instances) != 2 {
+		t.Fatalf("got %d instances, want 2", len(instances))
+	}
+
+	if instances[0].Name != "app1" || instances[0].Package != "main" {
+		t.Errorf("instances[0] = %+v, want Name=app1 Package=main", instances[0])
+	}
+	if instances[1].Name != "app2" || instances[1].Package != "staging" {
+		t.Errorf("instances[1] = %+v, want Name=app2 Package=staging", instances[1])
+	}
+}
+
+func TestLoadBundleInstancesNoInstancesField(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bundle.cue")
+	if err := os.WriteFile(file, []byte(`foo: "bar"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadBundleInstances(cuecontext.New(), file); err == nil {
+		t.Fatal("expected an error for a bundle with no instances field, got nil")
+	}
+}
+
+func TestLoadBundleInstancesMissingFile(t *testing.T) {
+	if _, err := loadBundleInstances(cuecontext.New(), "/does/not/exist/bundle.cue"); err == nil {
+		t.Fatal("expected an error for a missing bundle file, got nil")
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling"
+	"github.com/fluxcd/pkg/ssa"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	timonimodv1alpha1 "github.com/stefanprodan/timoni/api/v1alpha1"
+	"github.com/stefanprodan/timoni/internal/controller"
+)
+
+var controllerCmd = &cobra.Command{
+	Use:   "controller",
+	Short: "Run the in-cluster operator that reconciles Module objects",
+	Long: `The controller command starts a controller-runtime manager that watches
+Module custom resources and applies them using the same build/apply
+pipeline as the CLI's apply command.`,
+	RunE: runControllerCmd,
+}
+
+type controllerFlags struct {
+	leaderElect bool
+	metricsAddr string
+}
+
+var controllerArgs controllerFlags
+
+func init() {
+	controllerCmd.Flags().BoolVar(&controllerArgs.leaderElect, "leader-elect", false,
+		"enable leader election so that only one controller replica is active")
+	controllerCmd.Flags().StringVar(&controllerArgs.metricsAddr, "metrics-bind-address", ":8080",
+		"address the metrics endpoint binds to")
+	rootCmd.AddCommand(controllerCmd)
+}
+
+func runControllerCmd(cmd *cobra.Command, args []string) error {
+	scheme := runtimeScheme()
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:           scheme,
+		LeaderElection:   controllerArgs.leaderElect,
+		LeaderElectionID: "timoni-controller-leader-election",
+		Metrics:          metricsserver.Options{BindAddress: controllerArgs.metricsAddr},
+	})
+	if err != nil {
+		return fmt.Errorf("starting manager failed, error: %w", err)
+	}
+
+	so := ssa.Owner{
+		Field: "timoni",
+		Group: "timoni.mod",
+	}
+
+	statusPoller := polling.NewStatusPoller(mgr.GetClient(), mgr.GetRESTMapper(), polling.Options{})
+
+	reconciler := &controller.ModuleReconciler{
+		Client:       mgr.GetClient(),
+		Owner:        so,
+		StatusPoller: statusPoller,
+		NewFetcher: func(ctx context.Context, module, version, dir, creds string) controller.Fetcher {
+			return NewFetcher(ctx, module, version, dir, creds)
+		},
+		NewBuilder: func(name, namespace, modulePath, pkg string) controller.Builder {
+			return NewBuilder(cuecontext.New(), name, namespace, modulePath, pkg)
+		},
+	}
+
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("setting up the Module controller failed, error: %w", err)
+	}
+
+	logger.Println("starting controller, leader-elect:", controllerArgs.leaderElect)
+
+	return mgr.Start(ctrl.SetupSignalHandler())
+}
+
+func runtimeScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = timonimodv1alpha1.AddToScheme(scheme)
+	return scheme
+}
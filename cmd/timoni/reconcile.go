@@ -0,0 +1,270 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/fluxcd/pkg/ssa"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"github.com/stefanprodan/timoni/pkg/inventory"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Continuously reconcile the instances applied on the cluster",
+	Long: `The reconcile command runs a long-lived process that, for every instance
+recorded in the cluster inventories, periodically re-fetches the instance's
+source module and diffs the rebuilt objects against the live cluster state.
+Depending on --mode, drift is either just reported or re-applied. The set of
+instances is re-scanned on every interval, so instances applied or removed
+after the daemon starts are picked up or stopped without a restart.`,
+	RunE: runReconcileCmd,
+}
+
+type reconcileFlags struct {
+	interval    time.Duration
+	mode        string
+	metricsAddr string
+	creds       string
+}
+
+var reconcileArgs reconcileFlags
+
+func init() {
+	reconcileCmd.Flags().DurationVar(&reconcileArgs.interval, "interval", time.Minute,
+		"the interval at which instances are reconciled and the instance list is refreshed")
+	reconcileCmd.Flags().StringVar(&reconcileArgs.mode, "mode", "detect",
+		"the reconciliation mode, can be 'detect' or 'correct'")
+	reconcileCmd.Flags().StringVar(&reconcileArgs.metricsAddr, "metrics-addr", ":9090",
+		"address the /metrics endpoint binds to")
+	reconcileCmd.Flags().StringVar(&reconcileArgs.creds, "creds", "",
+		"credentials for the container registry in the format <username>[:<password>]")
+	rootCmd.AddCommand(reconcileCmd)
+}
+
+var (
+	driftDetectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "timoni_drift_detected_total",
+		Help: "Number of drifted resources detected, per instance.",
+	}, []string{"instance"})
+	driftCorrectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "timoni_drift_corrected_total",
+		Help: "Number of drifted resources corrected, per instance.",
+	}, []string{"instance"})
+	driftErroredTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "timoni_drift_errored_total",
+		Help: "Number of reconciliation errors, per instance.",
+	}, []string{"instance"})
+)
+
+// driftEvent is printed to stdout as JSON whenever a reconciled object
+// diverges from the state recorded by its source module.
+type driftEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Instance  string    `json:"instance"`
+	Namespace string    `json:"namespace"`
+	Object    string    `json:"object"`
+	Action    string    `json:"action"`
+}
+
+func runReconcileCmd(cmd *cobra.Command, args []string) error {
+	if reconcileArgs.mode != "detect" && reconcileArgs.mode != "correct" {
+		return fmt.Errorf("invalid --mode %q, must be 'detect' or 'correct'", reconcileArgs.mode)
+	}
+	if reconcileArgs.interval <= 0 {
+		return fmt.Errorf("--interval must be greater than zero")
+	}
+
+	so := ssa.Owner{
+		Field: "timoni",
+		Group: "timoni.mod",
+	}
+
+	sm, err := newManager(so)
+	if err != nil {
+		return err
+	}
+
+	invStorage := &inventory.Storage{Manager: sm, Owner: so}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go manageInstances(ctx, sm, invStorage)
+
+	http.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(reconcileArgs.metricsAddr, nil)
+}
+
+// manageInstances keeps one reconciliation goroutine running per instance
+// recorded in the cluster inventories, re-scanning the inventory list on
+// every interval so instances applied or deleted after startup are started
+// or stopped accordingly.
+func manageInstances(ctx context.Context, sm *ssa.ResourceManager, invStorage *inventory.Storage) {
+	var mu sync.Mutex
+	running := make(map[string]context.CancelFunc)
+
+	scan := func() {
+		instances, err := invStorage.List(ctx)
+		if err != nil {
+			logger.Println(fmt.Sprintf("listing instances failed, error: %v", err))
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		seen := make(map[string]bool, len(instances))
+		for _, i := range instances {
+			key := i.Namespace + "/" + i.Name
+			seen[key] = true
+			if _, ok := running[key]; ok {
+				continue
+			}
+
+			instanceCtx, instanceCancel := context.WithCancel(ctx)
+			running[key] = instanceCancel
+			logger.Println("reconciling instance", i.Name)
+			go reconcileInstance(instanceCtx, sm, i)
+		}
+
+		for name, stop := range running {
+			if !seen[name] {
+				logger.Println("instance", name, "no longer in inventory, stopping reconciliation")
+				stop()
+				delete(running, name)
+			}
+		}
+	}
+
+	scan()
+
+	ticker := time.NewTicker(reconcileArgs.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scan()
+		}
+	}
+}
+
+// reconcileInstance runs the reconciliation loop for a single instance on a
+// jittered ticker, so that instances sharing a registry don't all refresh at
+// the same time.
+func reconcileInstance(ctx context.Context, sm *ssa.ResourceManager, i *inventory.Instance) {
+	jitter := time.Duration(rand.Int63n(int64(reconcileArgs.interval)))
+	timer := time.NewTimer(jitter)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := reconcileOnce(ctx, sm, i); err != nil {
+				driftErroredTotal.WithLabelValues(i.Name).Inc()
+				logger.Println(fmt.Sprintf("instance %s: %v", i.Name, err))
+			}
+			timer.Reset(reconcileArgs.interval)
+		}
+	}
+}
+
+func reconcileOnce(ctx context.Context, sm *ssa.ResourceManager, i *inventory.Instance) error {
+	tmpDir, err := os.MkdirTemp("", "timoni-reconcile")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fetcher := NewFetcher(ctx, i.Source, i.Revision, tmpDir, reconcileArgs.creds)
+	modulePath, err := fetcher.Fetch()
+	if err != nil {
+		return fmt.Errorf("fetching module failed, error: %w", err)
+	}
+
+	pkg := i.Package
+	if pkg == "" {
+		pkg = "main"
+	}
+
+	cuectx := cuecontext.New()
+	builder := NewBuilder(cuectx, i.Name, i.Namespace, modulePath, pkg)
+
+	buildResult, err := builder.Build()
+	if err != nil {
+		return fmt.Errorf("build failed, error: %w", err)
+	}
+
+	objects, err := builder.GetObjects(buildResult)
+	if err != nil {
+		return fmt.Errorf("extracting resources failed, error: %w", err)
+	}
+
+	sm.SetOwnerLabels(objects, i.Name, i.Namespace)
+
+	// The desired objects are diffed against the live cluster state on
+	// every tick: drift is a property of the live state, which can change
+	// independently of the module, so there is no safe way to skip it.
+	diffOpts := ssa.DefaultDiffOptions()
+	for _, obj := range objects {
+		change, _, _, err := sm.Diff(ctx, obj, diffOpts)
+		if err != nil {
+			return err
+		}
+
+		if change.Action == string(ssa.ConfiguredAction) || change.Action == string(ssa.CreatedAction) {
+			driftDetectedTotal.WithLabelValues(i.Name).Inc()
+
+			event := driftEvent{
+				Timestamp: time.Now(),
+				Instance:  i.Name,
+				Namespace: i.Namespace,
+				Object:    change.Subject,
+				Action:    change.Action,
+			}
+			enc, _ := json.Marshal(event)
+			fmt.Println(string(enc))
+
+			if reconcileArgs.mode == "correct" {
+				if _, err := sm.ApplyAllStaged(ctx, []*unstructured.Unstructured{obj}, ssa.DefaultApplyOptions()); err != nil {
+					return fmt.Errorf("correcting drift failed, error: %w", err)
+				}
+				driftCorrectedTotal.WithLabelValues(i.Name).Inc()
+			}
+		}
+	}
+
+	return nil
+}
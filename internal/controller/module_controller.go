@@ -0,0 +1,322 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package controller implements the reconciler for the timoni.sh Module
+// custom resource, reusing the same build/apply pipeline as `timoni apply`.
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cuelang.org/go/cue"
+	"github.com/fluxcd/cli-utils/pkg/kstatus/polling"
+	"github.com/fluxcd/pkg/ssa"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	timonimodv1alpha1 "github.com/stefanprodan/timoni/api/v1alpha1"
+	"github.com/stefanprodan/timoni/pkg/inventory"
+)
+
+// stalledError marks a reconciliation failure that a requeue can't fix on
+// its own, because it requires a change to the Module spec.
+type stalledError struct {
+	reason string
+	err    error
+}
+
+func (e *stalledError) Error() string { return e.err.Error() }
+func (e *stalledError) Unwrap() error { return e.err }
+
+// ModuleReconciler reconciles a Module object using the same build, diff
+// and apply pipeline as the `timoni apply` command.
+type ModuleReconciler struct {
+	client.Client
+
+	// NewFetcher builds the OCI/local fetcher for a module reference,
+	// matching the helper used by the CLI commands.
+	NewFetcher func(ctx context.Context, module, version, dir, creds string) Fetcher
+
+	// NewBuilder builds the CUE instance for a module, matching the helper
+	// used by the CLI commands.
+	NewBuilder func(name, namespace, modulePath, pkg string) Builder
+
+	// StatusPoller is shared across reconciliations so that the resource
+	// manager's sm.Wait can poll object readiness, the same way the CLI's
+	// newManager wires it up.
+	StatusPoller *polling.StatusPoller
+
+	Owner ssa.Owner
+}
+
+// Fetcher mirrors the subset of cmd/timoni's Fetcher used by the reconciler.
+type Fetcher interface {
+	Fetch() (string, error)
+}
+
+// Builder mirrors the subset of cmd/timoni's Builder used by the reconciler.
+type Builder interface {
+	MergeValuesFile(filenames []string) error
+	Build() (cue.Value, error)
+	GetObjects(buildResult cue.Value) ([]*unstructured.Unstructured, error)
+}
+
+// +kubebuilder:rbac:groups=timoni.sh,resources=modules,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=timoni.sh,resources=modules/status,verbs=get;update;patch
+
+func (r *ModuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	var mod timonimodv1alpha1.Module
+	if err := r.Get(ctx, req.NamespacedName, &mod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	setCondition(&mod, timonimodv1alpha1.ModuleReconcilingCondition, metav1.ConditionTrue, "Progressing", "reconciliation in progress")
+	if err := r.Status().Update(ctx, &mod); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, recErr := r.reconcile(ctx, &mod)
+
+	var stalled *stalledError
+	switch {
+	case errors.As(recErr, &stalled):
+		setCondition(&mod, timonimodv1alpha1.ModuleReadyCondition, metav1.ConditionFalse, "Stalled", stalled.Error())
+		setCondition(&mod, timonimodv1alpha1.ModuleStalledCondition, metav1.ConditionTrue, stalled.reason, stalled.Error())
+		log.Error(recErr, "reconciliation stalled, it won't be retried until the spec changes")
+	case recErr != nil:
+		setCondition(&mod, timonimodv1alpha1.ModuleReadyCondition, metav1.ConditionFalse, "ReconcileError", recErr.Error())
+		setCondition(&mod, timonimodv1alpha1.ModuleStalledCondition, metav1.ConditionFalse, "Retrying", "retrying after a transient error")
+		log.Error(recErr, "reconciliation failed")
+	default:
+		setCondition(&mod, timonimodv1alpha1.ModuleReadyCondition, metav1.ConditionTrue, "ReconcileSuccess", "applied revision "+mod.Spec.Version)
+		setCondition(&mod, timonimodv1alpha1.ModuleStalledCondition, metav1.ConditionFalse, "Ready", "module is ready")
+		mod.Status.LastAppliedRevision = mod.Spec.Version
+	}
+	setCondition(&mod, timonimodv1alpha1.ModuleReconcilingCondition, metav1.ConditionFalse, "Idle", "waiting for next reconciliation")
+
+	if err := r.Status().Update(ctx, &mod); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if stalled != nil {
+		// Spec-level error: retrying immediately can't help, wait for a
+		// spec update (which triggers its own reconcile) instead of
+		// hammering the registry/API server.
+		return ctrl.Result{}, nil
+	}
+
+	if recErr != nil {
+		// Requeue with exponential backoff, controller-runtime applies the
+		// backoff based on the number of consecutive errors for this key.
+		return ctrl.Result{}, recErr
+	}
+
+	return result, nil
+}
+
+func (r *ModuleReconciler) reconcile(ctx context.Context, mod *timonimodv1alpha1.Module) (ctrl.Result, error) {
+	if mod.Spec.Module == "" {
+		return ctrl.Result{}, &stalledError{reason: "InvalidSpec", err: fmt.Errorf("spec.module is required")}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "timoni-controller")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fetcher := r.NewFetcher(ctx, mod.Spec.Module, mod.Spec.Version, tmpDir, "")
+	modulePath, err := fetcher.Fetch()
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("fetching module failed, error: %w", err)
+	}
+
+	pkg := mod.Spec.Package
+	if pkg == "" {
+		pkg = "main"
+	}
+
+	builder := r.NewBuilder(mod.Name, mod.Namespace, modulePath, pkg)
+
+	var valuesFiles []string
+	if mod.Spec.Values != "" {
+		inlineFile := filepath.Join(tmpDir, "values-inline.cue")
+		if err := os.WriteFile(inlineFile, []byte(mod.Spec.Values), 0644); err != nil {
+			return ctrl.Result{}, err
+		}
+		valuesFiles = append(valuesFiles, inlineFile)
+	}
+	for _, ref := range mod.Spec.ValuesFrom {
+		file, err := r.dumpValuesRef(ctx, mod.Namespace, ref, tmpDir)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		valuesFiles = append(valuesFiles, file)
+	}
+	if len(valuesFiles) > 0 {
+		if err := builder.MergeValuesFile(valuesFiles); err != nil {
+			return ctrl.Result{}, fmt.Errorf("merging values failed, error: %w", err)
+		}
+	}
+
+	buildResult, err := builder.Build()
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to build instance, error: %w", err)
+	}
+
+	objects, err := builder.GetObjects(buildResult)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to extract resouces, error: %w", err)
+	}
+
+	sm, err := ssa.NewResourceManager(r.Client, r.StatusPoller, r.Owner)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	sm.SetOwnerLabels(objects, mod.Name, mod.Namespace)
+
+	cs, err := sm.ApplyAllStaged(ctx, objects, ssa.DefaultApplyOptions())
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("apply failed, error: %w", err)
+	}
+
+	invStorage := &inventory.Storage{Manager: sm, Owner: r.Owner}
+	newInventory := inventory.NewInventory(mod.Name, mod.Namespace)
+	newInventory.SetSource(mod.Spec.Module, mod.Spec.Version, valuesFiles)
+	if err := newInventory.AddObjects(objects); err != nil {
+		return ctrl.Result{}, fmt.Errorf("creating inventory failed, error: %w", err)
+	}
+
+	staleObjects, err := invStorage.GetInventoryStaleObjects(ctx, newInventory)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("inventory query failed, error: %w", err)
+	}
+
+	if err := invStorage.ApplyInventory(ctx, newInventory, true); err != nil {
+		return ctrl.Result{}, fmt.Errorf("inventory apply failed, error: %w", err)
+	}
+
+	if mod.Spec.Prune && len(staleObjects) > 0 {
+		if _, err := sm.DeleteAll(ctx, staleObjects, ssa.DefaultDeleteOptions()); err != nil {
+			return ctrl.Result{}, fmt.Errorf("prune failed, error: %w", err)
+		}
+	}
+
+	mod.Status.Inventory = changeSetSubjects(cs)
+
+	if mod.Spec.Wait {
+		if err := sm.Wait(objects, ssa.DefaultWaitOptions()); err != nil {
+			return ctrl.Result{}, fmt.Errorf("waiting for resources failed, error: %w", err)
+		}
+	}
+
+	interval := mod.Spec.Interval.Duration
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// dumpValuesRef fetches the ConfigMap/Secret key referenced in
+// spec.valuesFrom and writes it to a temp CUE file so it can be passed to
+// the same MergeValuesFile path the CLI uses.
+func (r *ModuleReconciler) dumpValuesRef(ctx context.Context, namespace string, ref timonimodv1alpha1.ValuesReference, dir string) (string, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+
+	var data []byte
+	switch ref.Kind {
+	case "ConfigMap":
+		var cm corev1.ConfigMap
+		if err := r.Get(ctx, key, &cm); err != nil {
+			return "", fmt.Errorf("reading ConfigMap %s failed, error: %w", ref.Name, err)
+		}
+		if v, ok := cm.Data[ref.Key]; ok {
+			data = []byte(v)
+		} else if v, ok := cm.BinaryData[ref.Key]; ok {
+			data = v
+		} else {
+			return "", &stalledError{reason: "InvalidSpec",
+				err: fmt.Errorf("key %q not found in ConfigMap %s", ref.Key, ref.Name)}
+		}
+	case "Secret":
+		var secret corev1.Secret
+		if err := r.Get(ctx, key, &secret); err != nil {
+			return "", fmt.Errorf("reading Secret %s failed, error: %w", ref.Name, err)
+		}
+		v, ok := secret.Data[ref.Key]
+		if !ok {
+			return "", &stalledError{reason: "InvalidSpec",
+				err: fmt.Errorf("key %q not found in Secret %s", ref.Key, ref.Name)}
+		}
+		data = v
+	default:
+		return "", &stalledError{reason: "InvalidSpec",
+			err: fmt.Errorf("unsupported valuesFrom kind %q", ref.Kind)}
+	}
+
+	file := filepath.Join(dir, fmt.Sprintf("values-%s-%s.cue", ref.Kind, ref.Name))
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return "", err
+	}
+
+	return file, nil
+}
+
+func changeSetSubjects(cs *ssa.ChangeSet) []string {
+	if cs == nil {
+		return nil
+	}
+	subjects := make([]string, 0, len(cs.Entries))
+	for _, e := range cs.Entries {
+		subjects = append(subjects, e.Subject)
+	}
+	return subjects
+}
+
+func setCondition(mod *timonimodv1alpha1.Module, t timonimodv1alpha1.ModuleConditionType, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&mod.Status.Conditions, metav1.Condition{
+		Type:               string(t),
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: mod.Generation,
+	})
+}
+
+// SetupWithManager registers the reconciler with the controller-runtime
+// manager, watching Module objects. Only changes to the spec (a new
+// metadata.generation) trigger a reconcile, so the reconciler's own status
+// updates don't.
+func (r *ModuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&timonimodv1alpha1.Module{}).
+		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		Complete(r)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2023 Stefan Prodan
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fluxcd/pkg/ssa"
+)
+
+func TestChangeSetSubjects(t *testing.T) {
+	if got := changeSetSubjects(nil); got != nil {
+		t.Errorf("changeSetSubjects(nil) = %v, want nil", got)
+	}
+
+	cs := &ssa.ChangeSet{
+		Entries: []ssa.ChangeSetEntry{
+			{Subject: "Deployment/apps/web", Action: string(ssa.CreatedAction)},
+			{Subject: "Service/apps/web", Action: string(ssa.ConfiguredAction)},
+		},
+	}
+
+	want := []string{"Deployment/apps/web", "Service/apps/web"}
+	if got := changeSetSubjects(cs); !reflect.DeepEqual(got, want) {
+		t.Errorf("changeSetSubjects() = %v, want %v", got, want)
+	}
+}
+
+func TestChangeSetSubjectsEmpty(t *testing.T) {
+	cs := &ssa.ChangeSet{}
+	if got := changeSetSubjects(cs); len(got) != 0 {
+		t.Errorf("changeSetSubjects() = %v, want empty", got)
+	}
+}